@@ -1,11 +1,15 @@
 package ninjarouter
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -22,11 +26,29 @@ type Mux struct {
 	Timeout  time.Duration
 	listener ninjaListener
 	NotFound http.HandlerFunc
-	Port     int
-	Opened   func(*Mux)
-	Closed   func(*Mux)
-	Timed    bool
-	Log      func(...interface{})
+	// MethodNotAllowed is invoked, with the Allow header already set, when
+	// the path matches a route under a different method. Defaults to a
+	// plain 405 response.
+	MethodNotAllowed http.HandlerFunc
+	Port             int
+	Opened           func(*Mux)
+	Closed           func(*Mux)
+	Timed            bool
+	Log              func(...interface{})
+	// Observe, when set and Timed is true, is called after every request
+	// that matches a route, with the matched route pattern (not the raw URL, so cardinality
+	// stays bounded), the response status and the handler duration - e.g.
+	// to feed a Prometheus HistogramVec labelled by method, pattern,
+	// status.
+	Observe func(method, pattern, status string, dur time.Duration)
+
+	// Server, if set before Listen/ListenTLS is called, is reused as the
+	// underlying http.Server so callers can configure ReadTimeout,
+	// WriteTimeout, IdleTimeout, TLSConfig, MaxHeaderBytes, ErrorLog, etc.
+	// Its Handler, Addr and ConnState are overwritten by Listen/ListenTLS.
+	Server *http.Server
+
+	middleware []Middleware
 
 	idle   connections
 	active connections
@@ -54,53 +76,67 @@ func (nl ninjaListener) Addr() net.Addr {
 
 // Handler contains the pattern and handler func.
 type Handler struct {
-	patt     string
-	parts    []string
-	wild     bool
-	handlers []http.HandlerFunc
+	patt       string
+	parts      []string
+	wild       bool
+	handlers   []http.HandlerFunc
+	middleware []Middleware
+}
+
+// Middleware wraps an http.Handler to add cross-cutting behaviour (logging,
+// auth, recovery, compression, CORS, ...) around the handlers registered on
+// a route.
+type Middleware func(http.Handler) http.Handler
+
+// Group is a sub-router that shares its parent Mux's routing tree while
+// prepending prefix to every pattern it registers and composing its own
+// middleware with the parent's.
+type Group struct {
+	mux        *Mux
+	prefix     string
+	middleware []Middleware
+}
+
+func wrap(h http.Handler, mws []Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
 }
 
 type node struct {
 	pattern  string
 	handler  *Handler
 	children map[string]*node
-}
-
-var vars = struct {
-	sessions map[*http.Request]map[string]string
-	sync.Mutex
-}{
-	sessions: map[*http.Request]map[string]string{},
-}
-
-func deleteVars(r *http.Request) {
-	vars.Lock()
-	defer vars.Unlock()
-
-	delete(vars.sessions, r)
-}
 
-// Vars returns a map of variables associated with supplied request.
-func Vars(r *http.Request) map[string]string {
-	vars.Lock()
-	defer vars.Unlock()
-	if v, ok := vars.sessions[r]; ok {
-		return v
+	// constrained holds regex-constrained variable children ({name:regex}),
+	// tried in registration order after static and before unconstrained
+	// ({name}/:name) children. constrainedIndex dedupes them by
+	// "name:regex source" so sibling routes sharing an identical
+	// constrained segment reuse the same node instead of shadowing each
+	// other.
+	constrained      []*node
+	constrainedIndex map[string]*node
+	varName          string
+	re               *regexp.Regexp
+}
+
+type varsKey struct{}
+
+// Vars returns a map of variables associated with supplied request, and
+// whether any variables were found.
+func Vars(r *http.Request) (map[string]string, bool) {
+	v, ok := r.Context().Value(varsKey{}).(map[string]string)
+	if !ok {
+		return map[string]string{}, false
 	}
-	return map[string]string{}
+	return v, true
 }
 
 // Var returns named variable associated with supplied request
 func Var(r *http.Request, n string) string {
-	vars.Lock()
-	defer vars.Unlock()
-
-	if session, ok := vars.sessions[r]; ok {
-		if v, ok := session[n]; ok {
-			return v
-		}
-	}
-	return ""
+	v, _ := Vars(r)
+	return v[n]
 }
 
 // New returns a new Mux instance.
@@ -184,11 +220,42 @@ func (m *Mux) idleConnection(conn net.Conn) {
 	m.idle.Unlock()
 }
 
-// Listen starts a graceful HTTP server
-func (m *Mux) Listen(a string, statefns ...func(net.Conn, http.ConnState)) error {
+// server returns m.Server, configured with the Handler, Addr and ConnState
+// required to drive the connection-tracking pipeline, creating one if the
+// caller hasn't set Mux.Server.
+func (m *Mux) server(a string, statefns []func(net.Conn, http.ConnState)) *http.Server {
+	srv := m.Server
+	if srv == nil {
+		srv = &http.Server{}
+	}
+
+	srv.Handler = m
+	srv.Addr = a
+	srv.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			m.activeConnection(conn)
+		case http.StateActive:
+			m.activeConnection(conn)
+		case http.StateIdle:
+			m.idleConnection(conn)
+		case http.StateClosed, http.StateHijacked:
+			m.removeConnection(conn)
+		}
+		for _, connstate := range statefns {
+			connstate(conn, state)
+		}
+	}
+
+	return srv
+}
+
+// listen opens a TCP listener on a and records it, and its port, on m. It
+// factors out the setup shared by Listen and ListenTLS.
+func (m *Mux) listen(a string) (ninjaListener, error) {
 	l, err := net.Listen("tcp", a)
 	if err != nil {
-		return err
+		return ninjaListener{}, err
 	}
 
 	listener := ninjaListener{
@@ -198,114 +265,257 @@ func (m *Mux) Listen(a string, statefns ...func(net.Conn, http.ConnState)) error
 	m.listener = listener
 	m.Port = listener.Addr().(*net.TCPAddr).Port
 
-	//state := make(chan http.ConnState)
-
-	srv := &http.Server{
-		Handler: m,
-		Addr:    a,
-		ConnState: func(conn net.Conn, state http.ConnState) {
-			switch state {
-			case http.StateNew:
-				m.activeConnection(conn)
-			case http.StateActive:
-				m.activeConnection(conn)
-			case http.StateIdle:
-				m.idleConnection(conn)
-			case http.StateClosed, http.StateHijacked:
-				m.removeConnection(conn)
-			}
-			for _, connstate := range statefns {
-				connstate(conn, state)
-			}
-		},
+	return listener, nil
+}
+
+// Listen starts a graceful HTTP server
+func (m *Mux) Listen(a string, statefns ...func(net.Conn, http.ConnState)) error {
+	listener, err := m.listen(a)
+	if err != nil {
+		return err
 	}
 
+	srv := m.server(a, statefns)
+
 	m.Opened(m)
 
-	err = srv.Serve(listener)
+	return srv.Serve(listener)
+}
 
-	return err
+// ListenTLS starts a graceful HTTPS server using the certificate/key pair
+// at certFile/keyFile, wiring up the same connection-tracking pipeline and
+// graceful Close as Listen.
+func (m *Mux) ListenTLS(a, certFile, keyFile string, statefns ...func(net.Conn, http.ConnState)) error {
+	listener, err := m.listen(a)
+	if err != nil {
+		return err
+	}
+
+	srv := m.server(a, statefns)
+
+	m.Opened(m)
+
+	return srv.ServeTLS(listener, certFile, keyFile)
+}
+
+// parseVar reports whether seg is a variable segment, in either the legacy
+// ":name" form or the gorilla-style "{name}" / "{name:regex}" form. name is
+// empty for static segments; re is non-nil only for a regex-constrained
+// "{name:regex}" segment.
+func parseVar(seg string) (name string, re *regexp.Regexp) {
+	if strings.HasPrefix(seg, ":") {
+		return seg[1:], nil
+	}
+	if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+		inner := seg[1 : len(seg)-1]
+		if i := strings.Index(inner, ":"); i >= 0 {
+			return inner[:i], regexp.MustCompile("^" + inner[i+1:] + "$")
+		}
+		return inner, nil
+	}
+	return "", nil
 }
 
 func addnode(nd *node, n *node) {
 	segments := split(trim(n.pattern, "/"), "/")
 	for i, seg := range segments {
+		last := i == len(segments)-1
+
 		if seg == "*" {
 			nd.children["*"] = n
 			break
 		}
 
-		_, ok := nd.children[seg]
+		name, re := parseVar(seg)
+		if re != nil {
+			// Dedupe by varName+regex source so sibling routes sharing an
+			// identical constrained segment (e.g. two routes under
+			// "/a/{id:[0-9]+}/...") reuse the same trie node instead of
+			// shadowing one another under independent nodes.
+			key := name + ":" + re.String()
+			if nd.constrainedIndex == nil {
+				nd.constrainedIndex = make(map[string]*node)
+			}
+			existing, ok := nd.constrainedIndex[key]
+
+			var child *node
+			if !ok && !last {
+				child = &node{pattern: "empty", children: make(map[string]*node)}
+				child.varName, child.re = name, re
+				nd.constrainedIndex[key] = child
+				nd.constrained = append(nd.constrained, child)
+			} else if last {
+				child = n
+				child.varName, child.re = name, re
+				nd.constrainedIndex[key] = child
+				if ok {
+					child.children = existing.children
+					child.constrained = existing.constrained
+					child.constrainedIndex = existing.constrainedIndex
+					for idx, c := range nd.constrained {
+						if c == existing {
+							nd.constrained[idx] = child
+							break
+						}
+					}
+				} else {
+					nd.constrained = append(nd.constrained, child)
+				}
+				break
+			} else {
+				child = existing
+			}
+			nd = child
+			continue
+		}
+
+		key := seg
+		if name != "" {
+			key = ":" + name
+		}
 
-		if !ok && i < len(segments)-1 {
-			nd.children[seg] = &node{"empty", nil, make(map[string]*node)}
-		} else if i == len(segments)-1 {
-			nd.children[seg] = n
+		_, ok := nd.children[key]
+
+		if !ok && !last {
+			nd.children[key] = &node{pattern: "empty", children: make(map[string]*node)}
+		} else if last {
+			nd.children[key] = n
 			break
 		}
-		nd = nd.children[seg]
+		nd = nd.children[key]
 	}
 }
 
 // Add adds many handler funcs to a route
 func (m *Mux) Add(meth, patt string, handlers ...http.HandlerFunc) {
-	m.add(meth, patt, handlers)
+	m.add(meth, patt, handlers, nil)
 }
 
-func (m *Mux) add(meth, patt string, handlers []http.HandlerFunc) {
+func (m *Mux) add(meth, patt string, handlers []http.HandlerFunc, mws []Middleware) {
 	h := &Handler{
 		patt,
 		split(trim(patt, "/"), "/"),
 		patt[len(patt)-1:] == "*",
 		handlers,
+		mws,
 	}
 	if _, ok := m.root[meth]; !ok {
-		m.root[meth] = &node{"", nil, make(map[string]*node)}
+		m.root[meth] = &node{pattern: "", children: make(map[string]*node)}
 	}
 
 	n := node{
-		patt,
-		h,
-		make(map[string]*node),
+		pattern:  patt,
+		handler:  h,
+		children: make(map[string]*node),
 	}
 
 	addnode(m.root[meth], &n)
 }
 
+// Use appends mws to the mux-wide middleware chain. Middleware registered
+// here wraps every route's handlers, outer-most first: Use(a, b, c)
+// produces a(b(c(handler))).
+func (m *Mux) Use(mws ...Middleware) {
+	m.middleware = append(m.middleware, mws...)
+}
+
+// Group returns a sub-router sharing this Mux's routing tree that
+// prepends prefix to every pattern registered through it and composes mws
+// with the mux-wide middleware registered via Use.
+func (m *Mux) Group(prefix string, mws ...Middleware) *Group {
+	return &Group{
+		mux:        m,
+		prefix:     prefix,
+		middleware: mws,
+	}
+}
+
 // GET adds a new route for GET requests.
 func (m *Mux) GET(patt string, handlers ...http.HandlerFunc) {
-	m.add("GET", patt, handlers)
-	m.add("HEAD", patt, handlers)
+	m.add("GET", patt, handlers, nil)
+	m.add("HEAD", patt, handlers, nil)
 }
 
 // HEAD adds a new route for HEAD requests.
 func (m *Mux) HEAD(patt string, handlers ...http.HandlerFunc) {
-	m.add("HEAD", patt, handlers)
+	m.add("HEAD", patt, handlers, nil)
 }
 
 // POST adds a new route for POST requests.
 func (m *Mux) POST(patt string, handlers ...http.HandlerFunc) {
-	m.add("POST", patt, handlers)
+	m.add("POST", patt, handlers, nil)
 }
 
 // PUT adds a new route for PUT requests.
 func (m *Mux) PUT(patt string, handlers ...http.HandlerFunc) {
-	m.add("PUT", patt, handlers)
+	m.add("PUT", patt, handlers, nil)
 }
 
 // DELETE adds a new route for DELETE requests.
 func (m *Mux) DELETE(patt string, handlers ...http.HandlerFunc) {
-	m.add("DELETE", patt, handlers)
+	m.add("DELETE", patt, handlers, nil)
 }
 
 // OPTIONS adds a new route for OPTIONS requests.
 func (m *Mux) OPTIONS(patt string, handlers ...http.HandlerFunc) {
-	m.add("OPTIONS", patt, handlers)
+	m.add("OPTIONS", patt, handlers, nil)
 }
 
 // PATCH adds a new route for PATCH requests.
 func (m *Mux) PATCH(patt string, handlers ...http.HandlerFunc) {
-	m.add("PATCH", patt, handlers)
+	m.add("PATCH", patt, handlers, nil)
+}
+
+// pattern prepends the group's prefix to patt.
+func (g *Group) pattern(patt string) string {
+	prefix := trim(g.prefix, "/")
+	if prefix == "" {
+		return "/" + trim(patt, "/")
+	}
+	return "/" + prefix + "/" + trim(patt, "/")
+}
+
+// Add adds many handler funcs to a route under the group's prefix. The
+// route's handlers run wrapped by the mux-wide middleware registered via
+// Use followed by the group's own middleware.
+func (g *Group) Add(meth, patt string, handlers ...http.HandlerFunc) {
+	g.mux.add(meth, g.pattern(patt), handlers, g.middleware)
+}
+
+// GET adds a new route for GET requests.
+func (g *Group) GET(patt string, handlers ...http.HandlerFunc) {
+	g.Add("GET", patt, handlers...)
+	g.Add("HEAD", patt, handlers...)
+}
+
+// HEAD adds a new route for HEAD requests.
+func (g *Group) HEAD(patt string, handlers ...http.HandlerFunc) {
+	g.Add("HEAD", patt, handlers...)
+}
+
+// POST adds a new route for POST requests.
+func (g *Group) POST(patt string, handlers ...http.HandlerFunc) {
+	g.Add("POST", patt, handlers...)
+}
+
+// PUT adds a new route for PUT requests.
+func (g *Group) PUT(patt string, handlers ...http.HandlerFunc) {
+	g.Add("PUT", patt, handlers...)
+}
+
+// DELETE adds a new route for DELETE requests.
+func (g *Group) DELETE(patt string, handlers ...http.HandlerFunc) {
+	g.Add("DELETE", patt, handlers...)
+}
+
+// OPTIONS adds a new route for OPTIONS requests.
+func (g *Group) OPTIONS(patt string, handlers ...http.HandlerFunc) {
+	g.Add("OPTIONS", patt, handlers...)
+}
+
+// PATCH adds a new route for PATCH requests.
+func (g *Group) PATCH(patt string, handlers ...http.HandlerFunc) {
+	g.Add("PATCH", patt, handlers...)
 }
 
 func hh(w http.ResponseWriter, r *http.Request) {}
@@ -321,6 +531,41 @@ func (m *Mux) notFound(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+func (m *Mux) methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	if m.MethodNotAllowed != nil {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		m.MethodNotAllowed.ServeHTTP(w, r)
+		return
+	}
+	http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+}
+
+// serveOptions auto-answers an OPTIONS request that has no explicit
+// handler registered, with the computed Allow header and an empty 204.
+func (m *Mux) serveOptions(w http.ResponseWriter, r *http.Request, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowedMethods returns the methods, sorted, that have a route matching
+// segments. If segments is nil it returns every method with at least one
+// route registered, for the server-wide "OPTIONS *" request.
+func (m *Mux) allowedMethods(segments []string) []string {
+	var allowed []string
+	for meth, root := range m.root {
+		if segments == nil {
+			allowed = append(allowed, meth)
+			continue
+		}
+		if _, _, ok := match(root, segments); ok {
+			allowed = append(allowed, meth)
+		}
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
 // HandlerFunc takes a stdlib Handler and returns itself
 func (m *Mux) HandlerFunc(h http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -335,6 +580,146 @@ func HandlerFunc(h http.Handler) http.HandlerFunc {
 	})
 }
 
+// match walks root (a method's route tree) against segments, returning the
+// matched leaf node and any bound path variables. Precedence at each
+// segment: static > regex-constrained ({name:regex}) > unconstrained
+// ({name}/:name) > "*" wildcard.
+func match(nd *node, segments []string) (*node, map[string]string, bool) {
+	if len(segments) == 0 {
+		if nd.handler != nil {
+			return nd, map[string]string{}, true
+		}
+		if w, ok := nd.children["*"]; ok {
+			return w, map[string]string{}, true
+		}
+		return nil, nil, false
+	}
+
+	seg, rest := segments[0], segments[1:]
+
+	// Static children are tried first; if one matches this segment but the
+	// rest of the path doesn't resolve underneath it, backtrack and try
+	// the remaining candidates below rather than committing to it.
+	if xnode, ok := nd.children[seg]; ok {
+		if n, vrs, ok := match(xnode, rest); ok {
+			return n, vrs, true
+		}
+	}
+
+	for _, c := range nd.constrained {
+		if !c.re.MatchString(seg) {
+			continue
+		}
+		if n, vrs, ok := match(c, rest); ok {
+			vrs[c.varName] = seg
+			return n, vrs, true
+		}
+	}
+
+	for k, v := range nd.children {
+		if len(k) == 0 || k[0] != ':' {
+			continue
+		}
+		if n, vrs, ok := match(v, rest); ok {
+			vrs[strings.TrimPrefix(k, ":")] = seg
+			return n, vrs, true
+		}
+	}
+
+	if w, ok := nd.children["*"]; ok {
+		return w, map[string]string{}, true
+	}
+
+	return nil, nil, false
+}
+
+// statusWriter wraps an http.ResponseWriter to capture the status code
+// written for it, defaulting to 200 if WriteHeader is never called.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.status = code
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Flush forwards to the underlying ResponseWriter's http.Flusher, if any,
+// so streaming handlers keep working under Mux.Timed.
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack forwards to the underlying ResponseWriter's http.Hijacker, if
+// any, so handlers that upgrade the connection (e.g. WebSockets) keep
+// working under Mux.Timed.
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("ninjarouter: underlying ResponseWriter does not support http.Hijacker")
+	}
+	return h.Hijack()
+}
+
+// Push forwards to the underlying ResponseWriter's http.Pusher, if any.
+func (sw *statusWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := sw.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// RouteInfo describes a single registered route, as returned by Routes.
+type RouteInfo struct {
+	Method  string
+	Pattern string
+	Handler *Handler
+}
+
+func walkNode(meth string, nd *node, fn func(method, pattern string, handler *Handler) error) error {
+	if nd.handler != nil {
+		if err := fn(meth, nd.handler.patt, nd.handler); err != nil {
+			return err
+		}
+	}
+	for _, child := range nd.children {
+		if err := walkNode(meth, child, fn); err != nil {
+			return err
+		}
+	}
+	for _, child := range nd.constrained {
+		if err := walkNode(meth, child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk traverses the per-method route trie, calling fn for every
+// registered route. It stops and returns the first non-nil error from fn.
+func (m *Mux) Walk(fn func(method, pattern string, handler *Handler) error) error {
+	for meth, root := range m.root {
+		if err := walkNode(meth, root, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Routes returns every registered route.
+func (m *Mux) Routes() []RouteInfo {
+	var routes []RouteInfo
+	m.Walk(func(method, pattern string, handler *Handler) error {
+		routes = append(routes, RouteInfo{method, pattern, handler})
+		return nil
+	})
+	return routes
+}
+
 func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	l := len(r.URL.Path)
@@ -343,81 +728,63 @@ func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, r.URL.Path[:l-1], 301)
 		return
 	}
+
+	if r.Method == http.MethodOptions && r.URL.Path == "*" {
+		m.serveOptions(w, r, m.allowedMethods(nil))
+		return
+	}
+
 	// Split the URL into segments.
 	segments := split(trim(r.URL.Path, "/"), "/")
 
-	var ok bool
-	var xnode *node
 	var nd *node
+	var vrs map[string]string
+	var ok bool
 
-	vrs := make(map[string]string)
-
-	if nd, ok = m.root[r.Method]; !ok {
-		m.notFound(w, r)
-		return
+	if root, exists := m.root[r.Method]; exists {
+		nd, vrs, ok = match(root, segments)
 	}
 
-	for i, seg := range segments {
-		if xnode, ok = nd.children[seg]; !ok {
-			if xnode, ok = nd.children["*"]; ok {
-				nd = xnode
-				break
-			}
-
-			//check for variables
-
-			for k, v := range nd.children {
-				if len(k) > 0 {
-					if string([]rune(k)[0]) == ":" {
-						nd = v
-						vrs[strings.TrimPrefix(k, ":")] = seg
-						break
-					}
-				}
-			}
-			if len(vrs) > 0 {
-				if i > len(segments) {
-					break
-				} else {
-					continue
-				}
-			}
-			//check for custom 404
+	if !ok {
+		allowed := m.allowedMethods(segments)
+		switch {
+		case r.Method == http.MethodOptions && len(allowed) > 0:
+			m.serveOptions(w, r, allowed)
+		case len(allowed) > 0:
+			m.methodNotAllowed(w, r, allowed)
+		default:
 			m.notFound(w, r)
-			return
-		}
-		if xnode.pattern == "empty" && i == len(segments)-1 {
-			if xnode, ok = xnode.children["*"]; ok {
-				nd = xnode
-				break
-			}
 		}
-
-		nd = xnode
+		return
 	}
 
-	if nd == nil {
-		m.notFound(w, r)
-		return
+	if len(vrs) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), varsKey{}, vrs))
 	}
 
-	ctx := context.Background()
+	final := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.Timed {
+			for _, handler := range nd.handler.handlers {
+				handler.ServeHTTP(w, r)
+			}
+			return
+		}
 
-	for _, handler := range nd.handler.handlers {
-		r = r.WithContext(ctx)
-		if len(vrs) > 0 {
-			vars.Lock()
-			vars.sessions[r] = vrs
-			vars.Unlock()
-			defer deleteVars(r)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		t1 := time.Now()
+		for _, handler := range nd.handler.handlers {
+			handler.ServeHTTP(sw, r)
 		}
-		if m.Timed {
-			t1 := time.Now()
-			handler.ServeHTTP(w, r)
-			t2 := time.Now()
-			m.Log(fmt.Sprintf("[%s] %q %v\n", r.Method, r.URL.String(), t2.Sub(t1)))
-		} else {
-			handler.ServeHTTP(w, r)
+		dur := time.Since(t1)
+		m.Log(fmt.Sprintf("[%s] %q %v\n", r.Method, r.URL.String(), dur))
+		if m.Observe != nil {
+			m.Observe(r.Method, nd.handler.patt, strconv.Itoa(sw.status), dur)
 		}
-	}
+	})
+
+	mws := make([]Middleware, 0, len(m.middleware)+len(nd.handler.middleware))
+	mws = append(mws, m.middleware...)
+	mws = append(mws, nd.handler.middleware...)
+
+	wrap(final, mws).ServeHTTP(w, r)
 }
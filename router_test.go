@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func Benchmark_GET_Simple(b *testing.B) {
@@ -29,6 +30,128 @@ func Benchmark_GET_Extreme(b *testing.B) {
 	}
 }
 
+func Test_Use_Group(t *testing.T) {
+	var order []string
+
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	router := New()
+	router.Use(tag("a"), tag("b"))
+
+	api := router.Group("/api", tag("c"))
+	api.GET("/ping", helloHandler)
+
+	rw, req := testRequest("GET", "/api/ping")
+	router.ServeHTTP(rw, req)
+
+	want := []string{"a", "b", "c"}
+	if len(order) != len(want) {
+		t.Fatalf("expected middleware order %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected middleware order %v, got %v", want, order)
+		}
+	}
+	if rw.Body.String() != "hello" {
+		t.Fatalf("expected body %q, got %q", "hello", rw.Body.String())
+	}
+}
+
+func Test_RegexVars(t *testing.T) {
+	router := New()
+	router.GET("/users/{id:[0-9]+}", helloVarsHandler)
+	router.GET("/users/{name}", helloVarsHandler)
+
+	rw, req := testRequest("GET", "/users/42")
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 for numeric id, got %d", rw.Code)
+	}
+
+	rw, req = testRequest("GET", "/users/bob")
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 for name fallback, got %d", rw.Code)
+	}
+}
+
+func Test_RegexVars_SharedSiblingPrefix(t *testing.T) {
+	router := New()
+	router.GET("/a/{id:[0-9]+}/friends", helloVarsHandler)
+	router.GET("/a/{id:[0-9]+}/foes", helloVarsHandler)
+
+	rw, req := testRequest("GET", "/a/123/friends")
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 for /a/123/friends, got %d", rw.Code)
+	}
+
+	rw, req = testRequest("GET", "/a/123/foes")
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 for /a/123/foes, got %d", rw.Code)
+	}
+}
+
+func Test_MethodNotAllowed_And_Options(t *testing.T) {
+	router := New()
+	router.GET("/action", helloHandler)
+	router.POST("/action", helloHandler)
+
+	rw, req := testRequest("DELETE", "/action")
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rw.Code)
+	}
+	if allow := rw.Header().Get("Allow"); allow != "GET, HEAD, POST" {
+		t.Fatalf("expected Allow header %q, got %q", "GET, HEAD, POST", allow)
+	}
+
+	rw, req = testRequest("OPTIONS", "/action")
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rw.Code)
+	}
+	if allow := rw.Header().Get("Allow"); allow != "GET, HEAD, POST" {
+		t.Fatalf("expected Allow header %q, got %q", "GET, HEAD, POST", allow)
+	}
+}
+
+func Test_Routes_And_Observe(t *testing.T) {
+	router := New()
+	router.GET("/action", helloHandler)
+
+	routes := router.Routes()
+	if len(routes) != 2 { // GET + HEAD
+		t.Fatalf("expected 2 registered routes, got %d", len(routes))
+	}
+
+	var gotMethod, gotPattern, gotStatus string
+	var gotDur time.Duration
+	router.Timed = true
+	router.Observe = func(method, pattern, status string, dur time.Duration) {
+		gotMethod, gotPattern, gotStatus, gotDur = method, pattern, status, dur
+	}
+
+	rw, req := testRequest("GET", "/action")
+	router.ServeHTTP(rw, req)
+
+	if gotMethod != "GET" || gotPattern != "/action" || gotStatus != "200" {
+		t.Fatalf("expected Observe(GET, /action, 200, ...), got (%s, %s, %s)", gotMethod, gotPattern, gotStatus)
+	}
+	if gotDur < 0 {
+		t.Fatalf("expected non-negative duration, got %v", gotDur)
+	}
+}
+
 func helloHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "hello")
 }
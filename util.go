@@ -0,0 +1,11 @@
+package ninjarouter
+
+import "strings"
+
+func split(s, sep string) []string {
+	return strings.Split(s, sep)
+}
+
+func trim(s, cutset string) string {
+	return strings.Trim(s, cutset)
+}